@@ -0,0 +1,147 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var interpolationPattern = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// interpolateRawConfig walks raw, a value produced by unmarshalling one of
+// the supported config formats, and expands '${env:VAR}',
+// '${env:VAR:-default}' and '${file:/path}' tokens found inside string
+// values. It lets operators keep most settings in the config file while
+// sourcing secrets, such as a token consumed by a pipe module, from the
+// environment or a mounted secret file instead of committing them to the
+// repo.
+//
+// In strict mode a referenced environment variable that is unset and has no
+// default is a load error; in lenient mode the token is left untouched.
+func interpolateRawConfig(raw interface{}, lenient bool) (interface{}, error) {
+	switch v := raw.(type) {
+	case string:
+		return interpolateString(v, lenient)
+	case map[string]interface{}:
+		for key, entry := range v {
+			resolved, err := interpolateRawConfig(entry, lenient)
+			if err != nil {
+				return nil, errors.Wrapf(err, "key '%s'", key)
+			}
+			v[key] = resolved
+		}
+		return v, nil
+	case []map[string]interface{}:
+		for _, entry := range v {
+			if _, err := interpolateRawConfig(entry, lenient); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case []interface{}:
+		for i, entry := range v {
+			resolved, err := interpolateRawConfig(entry, lenient)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = resolved
+		}
+		return v, nil
+	default:
+		return raw, nil
+	}
+}
+
+// interpolateString expands every '${...}' token found in raw.
+func interpolateString(raw string, lenient bool) (string, error) {
+	var outerErr error
+
+	result := interpolationPattern.ReplaceAllStringFunc(raw, func(match string) string {
+		if outerErr != nil {
+			return match
+		}
+
+		token := strings.TrimSuffix(strings.TrimPrefix(match, "${"), "}")
+
+		sepIdx := strings.Index(token, ":")
+		if sepIdx < 0 {
+			outerErr = errors.Errorf("unknown interpolation scheme in '%s'", match)
+			return match
+		}
+		scheme, rest := token[:sepIdx], token[sepIdx+1:]
+
+		switch scheme {
+		case "env":
+			name, def, hasDefault := cutDefault(rest)
+
+			value, found := os.LookupEnv(name)
+			switch {
+			case found:
+				return value
+			case hasDefault:
+				return def
+			case lenient:
+				return match
+			default:
+				outerErr = errors.Errorf("environment variable '%s' is not set", name)
+				return match
+			}
+		case "file":
+			payload, err := ioutil.ReadFile(rest)
+			if err != nil {
+				outerErr = errors.Wrapf(err, "read interpolation file '%s' error", rest)
+				return match
+			}
+			return strings.TrimSpace(string(payload))
+		default:
+			outerErr = errors.Errorf("unknown interpolation scheme '%s'", scheme)
+			return match
+		}
+	})
+
+	if outerErr != nil {
+		return "", outerErr
+	}
+	return result, nil
+}
+
+// cutDefault splits an 'env' token body on the ':-' default marker, e.g.
+// "PORT:-8080" becomes ("PORT", "8080", true).
+func cutDefault(raw string) (name, def string, hasDefault bool) {
+	idx := strings.Index(raw, ":-")
+	if idx < 0 {
+		return raw, "", false
+	}
+	return raw[:idx], raw[idx+2:], true
+}
+
+// rawServerInterpolationMode looks up the 'server.interpolation' setting
+// directly in the raw config map, before the mapstructure decode step,
+// since it governs how the interpolation pass itself behaves.
+func rawServerInterpolationMode(rawCfg map[string]interface{}) (lenient bool) {
+	var entries []map[string]interface{}
+
+	switch v := rawCfg["server"].(type) {
+	case map[string]interface{}:
+		entries = []map[string]interface{}{v}
+	case []map[string]interface{}:
+		entries = v
+	case []interface{}:
+		for _, entry := range v {
+			if m, ok := entry.(map[string]interface{}); ok {
+				entries = append(entries, m)
+			}
+		}
+	}
+
+	for _, entry := range entries {
+		if mode, _ := entry["interpolation"].(string); mode == "lenient" {
+			return true
+		}
+	}
+
+	return false
+}
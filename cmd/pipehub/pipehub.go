@@ -2,20 +2,56 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/ghodss/yaml"
+	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/hcl"
 	"github.com/mitchellh/mapstructure"
 	"github.com/pkg/errors"
 
 	"github.com/pipehub/pipehub"
+	"github.com/pipehub/pipehub/internal/hclconfig"
+	"github.com/pipehub/pipehub/internal/logging"
 )
 
+// loggerValue holds the current logging.Logger fatal and asyncErrHandler
+// log through. It's an atomic.Value rather than a plain package variable
+// because initLogger replaces it from a SIGHUP handler while those two
+// functions can be reading it concurrently from request-serving goroutines.
+var loggerValue atomic.Value
+
+func init() {
+	loggerValue.Store(mustDefaultLogger())
+}
+
+// logger returns the current logger. It starts out as the zero-config
+// default so startup errors before the config is loaded are still reported,
+// and is replaced once the 'server.logging' block, if any, has been
+// decoded.
+func logger() logging.Logger {
+	return loggerValue.Load().(logging.Logger)
+}
+
+func mustDefaultLogger() logging.Logger {
+	l, err := logging.New(logging.Config{})
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
 type config struct {
 	Host   []configHost   `mapstructure:"host"`
 	Pipe   []configPipe   `mapstructure:"pipe"`
@@ -23,16 +59,33 @@ type config struct {
 }
 
 func (c config) valid() error {
+	var result *multierror.Error
+
 	if len(c.Server) > 1 {
-		return errors.New("more then one 'server' config block found, only one is allowed")
+		result = multierror.Append(result, errors.New("more then one 'server' config block found, only one is allowed"))
 	}
 
 	for _, s := range c.Server {
 		if err := s.valid(); err != nil {
-			return err
+			result = multierror.Append(result, err)
 		}
 	}
-	return nil
+
+	aliases := make(map[string]bool, len(c.Pipe))
+	for _, pipe := range c.Pipe {
+		aliases[pipe.Alias] = true
+	}
+
+	for _, host := range c.Host {
+		switch {
+		case host.Handler == "":
+			result = multierror.Append(result, fmt.Errorf("host '%s': 'handler' is required", host.Endpoint))
+		case !aliases[host.Handler]:
+			result = multierror.Append(result, fmt.Errorf("host '%s': handler '%s' does not match any declared pipe alias", host.Endpoint, host.Handler))
+		}
+	}
+
+	return result.ErrorOrNil()
 }
 
 func (c config) toGenerateConfig() pipehub.GenerateConfig {
@@ -92,7 +145,7 @@ func (c config) ctxShutdown() (ctx context.Context, ctxCancel func()) {
 }
 
 type configPipe struct {
-	Path    string `mapstructure:"path"`
+	Path    string `mapstructure:"path,label"`
 	Version string `mapstructure:"version"`
 	Alias   string `mapstructure:"alias"`
 	Module  string `mapstructure:"module"`
@@ -104,20 +157,47 @@ type configHost struct {
 }
 
 type configServer struct {
-	GracefulShutdown string               `mapstructure:"graceful-shutdown"`
-	HTTP             []configServerHTTP   `mapstructure:"http"`
-	Action           []configServerAction `mapstructure:"action"`
+	GracefulShutdown string                `mapstructure:"graceful-shutdown"`
+	Interpolation    string                `mapstructure:"interpolation"`
+	HTTP             []configServerHTTP    `mapstructure:"http"`
+	Action           []configServerAction  `mapstructure:"action"`
+	Logging          []configServerLogging `mapstructure:"logging"`
 }
 
 func (c configServer) valid() error {
+	var result *multierror.Error
+
 	if len(c.HTTP) > 1 {
-		return errors.New("more then one 'server.http' config block found, only one is allowed")
+		result = multierror.Append(result, errors.New("more then one 'server.http' config block found, only one is allowed"))
 	}
 
 	if len(c.Action) > 1 {
-		return errors.New("more then one 'server.action' config block found, only one is allowed")
+		result = multierror.Append(result, errors.New("more then one 'server.action' config block found, only one is allowed"))
 	}
-	return nil
+
+	if len(c.Logging) > 1 {
+		result = multierror.Append(result, errors.New("more then one 'server.logging' config block found, only one is allowed"))
+	}
+
+	for _, http := range c.HTTP {
+		if http.Port < 1 || http.Port > 65535 {
+			result = multierror.Append(result, fmt.Errorf("server.http: port '%d' is out of range, must be between 1 and 65535", http.Port))
+		}
+	}
+
+	if c.GracefulShutdown != "" {
+		if _, err := time.ParseDuration(c.GracefulShutdown); err != nil {
+			result = multierror.Append(result, errors.Wrapf(err, "server: parse 'graceful-shutdown' duration '%s' error", c.GracefulShutdown))
+		}
+	}
+
+	switch c.Interpolation {
+	case "", "strict", "lenient":
+	default:
+		result = multierror.Append(result, fmt.Errorf("server: interpolation '%s' is invalid, must be 'strict' or 'lenient'", c.Interpolation))
+	}
+
+	return result.ErrorOrNil()
 }
 
 type configServerHTTP struct {
@@ -129,105 +209,277 @@ type configServerAction struct {
 	Panic    string `mapstructure:"panic"`
 }
 
+type configServerLogging struct {
+	Format string `mapstructure:"format"`
+	Level  string `mapstructure:"level"`
+	Output string `mapstructure:"output"`
+}
+
+func (c configServerLogging) toLoggingConfig() logging.Config {
+	return logging.Config{
+		Format: c.Format,
+		Level:  c.Level,
+		Output: c.Output,
+	}
+}
+
 func loadConfig(path string) (config, error) {
-	payload, err := ioutil.ReadFile(path)
+	rawCfg, err := loadRawConfig(path)
 	if err != nil {
-		return config{}, errors.Wrap(err, "load file error")
+		return config{}, err
 	}
 
-	// For some reason I can't unmarshal direct from the HCL to a struct, the array values get messed up.
-	// Unmarshalling to a map works fine, so we do this and later transform the map into the desired struct.
-	rawCfg := make(map[string]interface{})
-	if err = hcl.Unmarshal(payload, &rawCfg); err != nil {
-		return config{}, errors.Wrap(err, "unmarshal payload error")
+	interpolated, err := interpolateRawConfig(rawCfg, rawServerInterpolationMode(rawCfg))
+	if err != nil {
+		return config{}, errors.Wrap(err, "interpolate config error")
 	}
+	rawCfg = interpolated.(map[string]interface{})
 
 	var cfg config
-	if err := mapstructure.Decode(rawCfg, &cfg); err != nil {
+	var md mapstructure.Metadata
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			hclconfig.HookWeakDecodeMapToSlice,
+			hclconfig.HookWeakDecodeFromSlice,
+			hclconfig.HookWeakDecodeLabeledSlice,
+		),
+		WeaklyTypedInput: true,
+		Metadata:         &md,
+		Result:           &cfg,
+	})
+	if err != nil {
+		return config{}, errors.Wrap(err, "create decoder error")
+	}
+
+	if err := decoder.Decode(rawCfg); err != nil {
 		return config{}, errors.Wrap(err, "unmarshal error")
 	}
 
-	cfg.Pipe, err = loadConfigPipe(rawCfg["pipe"])
+	// Aggregate decode-time problems (unknown fields) with semantic ones
+	// (cfg.valid()) so a single run surfaces every config mistake at once,
+	// instead of a caller having to fix and re-run one error class at a
+	// time.
+	var result *multierror.Error
+	for _, field := range md.Unused {
+		container, name := splitUnusedField(field)
+		if container == "" {
+			result = multierror.Append(result, fmt.Errorf("unknown key '%s'", name))
+			continue
+		}
+		result = multierror.Append(result, fmt.Errorf("unknown key '%s' at %s", name, container))
+	}
+
+	if err := cfg.valid(); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	return cfg, result.ErrorOrNil()
+}
+
+// indexPattern strips the slice indices mapstructure.Metadata.Unused
+// reports (e.g. "server[0].http[0].prot"), so the reported path follows the
+// block nesting of the config file ("server.http"). For the 'host' and
+// 'pipe' blocks, which can repeat, this also drops which entry the key was
+// found in.
+var indexPattern = regexp.MustCompile(`\[\d+\]`)
+
+// splitUnusedField turns a raw mapstructure unused-field path, such as
+// "server[0].http[0].prot", into its container path ("server.http") and
+// leaf key ("prot"). container is empty when the field is top-level.
+func splitUnusedField(raw string) (container, name string) {
+	cleaned := indexPattern.ReplaceAllString(raw, "")
+
+	idx := strings.LastIndex(cleaned, ".")
+	if idx < 0 {
+		return "", cleaned
+	}
+	return cleaned[:idx], cleaned[idx+1:]
+}
+
+// loadRawConfig loads the config found at path into the intermediate map
+// form every supported format is unmarshalled into before the mapstructure
+// decode step. path may point to a single config file or, to support
+// splitting host routing rules from pipe declarations, a directory: every
+// file directly inside it is loaded in lexicographic order and deep-merged,
+// later files overriding scalar fields and appending to the 'host' and
+// 'pipe' blocks.
+func loadRawConfig(path string) (map[string]interface{}, error) {
+	info, err := os.Stat(path)
 	if err != nil {
-		return config{}, errors.Wrap(err, "unmarshal pipe config error")
+		return nil, errors.Wrap(err, "stat config path error")
+	}
+
+	if !info.IsDir() {
+		return loadRawConfigFile(path)
+	}
+
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read config dir error")
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	merged := make(map[string]interface{})
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		raw, err := loadRawConfigFile(filepath.Join(path, entry.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "load config file '%s' error", entry.Name())
+		}
+
+		mergeRawConfig(merged, raw)
 	}
 
-	return cfg, nil
+	return merged, nil
 }
 
-// loadConfigPipe expect to receive a interface with this format:
-//
-//	[]map[string]interface {}{
-//		{
-//				"github.com/pipehub/pipe": []map[string]interface {}{
-//						{
-//								"version": "v0.7.0",
-//								"alias":   "pipe",
-//						},
-//				},
-//		},
-//	}
-func loadConfigPipe(raw interface{}) ([]configPipe, error) {
-	var result []configPipe
-
-	if raw == nil {
-		return nil, nil
-	}
-
-	rawSliceMap, ok := raw.([]map[string]interface{})
-	if !ok {
-		return nil, errors.New("can't type assertion value into []map[string]interface{} on the first assignment")
-	}
-
-	for _, rawMap := range rawSliceMap {
-		for key, rawMapEntry := range rawMap {
-			rawSliceMapInner, ok := rawMapEntry.([]map[string]interface{})
-			if !ok {
-				return nil, errors.New("can't type assertion value into []map[string]interface{} on the second assignment")
-			}
+// loadRawConfigFile loads a single config file, dispatching on its
+// extension the way HashiCorp tools do.
+func loadRawConfigFile(path string) (map[string]interface{}, error) {
+	payload, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "load file error")
+	}
 
-			for _, rawSliceMapInnerEntry := range rawSliceMapInner {
-				ch := configPipe{
-					Path: key,
-				}
-
-				for innerKey, innerEntry := range rawSliceMapInnerEntry {
-					value, ok := innerEntry.(string)
-					if !ok {
-						return nil, errors.New("can't type assertion value into string")
-					}
-
-					switch innerKey {
-					case "version":
-						ch.Version = value
-					case "alias":
-						ch.Alias = value
-					case "module":
-						ch.Module = value
-					default:
-						return nil, fmt.Errorf("unknow pipe key '%s'", innerKey)
-					}
-				}
-
-				result = append(result, ch)
-			}
+	rawCfg := make(map[string]interface{})
+
+	switch ext := filepath.Ext(path); ext {
+	case ".hcl":
+		// For some reason I can't unmarshal direct from the HCL to a struct, the array values get messed up.
+		// Unmarshalling to a map works fine, so we do this and later transform the map into the desired struct.
+		if err := hcl.Unmarshal(payload, &rawCfg); err != nil {
+			return nil, errors.Wrap(err, "unmarshal hcl payload error")
+		}
+	case ".json":
+		if err := json.Unmarshal(payload, &rawCfg); err != nil {
+			return nil, errors.Wrap(err, "unmarshal json payload error")
+		}
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(payload, &rawCfg); err != nil {
+			return nil, errors.Wrap(err, "unmarshal yaml payload error")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config file extension '%s'", ext)
+	}
+
+	return rawCfg, nil
+}
+
+// mergeRawConfig deep-merges src into dst in place: scalar fields in src
+// override dst, while the 'host', 'pipe' and 'server' blocks are appended to
+// instead of replaced. 'host'/'pipe' are expected to be split across files;
+// 'server' isn't, but it must still accumulate here rather than have a
+// later file silently clobber an earlier one, so config.valid()'s "at most
+// one 'server' block" check has every declared block to look at.
+func mergeRawConfig(dst, src map[string]interface{}) {
+	for key, value := range src {
+		switch key {
+		case "host", "pipe", "server":
+			dst[key] = append(toRawSlice(dst[key]), toRawSlice(value)...)
+		default:
+			dst[key] = value
 		}
 	}
+}
+
+// toRawSlice normalizes the shapes the supported formats can produce for a
+// block ([]map[string]interface{} or a lone map from HCL, []interface{} or
+// a lone object from JSON/YAML) into a single []interface{} so entries from
+// different files can be appended together.
+func toRawSlice(raw interface{}) []interface{} {
+	switch v := raw.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return v
+	case []map[string]interface{}:
+		result := make([]interface{}, len(v))
+		for i, entry := range v {
+			result[i] = entry
+		}
+		return result
+	default:
+		return []interface{}{v}
+	}
+}
 
-	return result, nil
+// initLogger rebuilds the logger from the 'server.logging' config block, if
+// one was declared, and atomically swaps it into loggerValue so every log
+// call made afterwards picks up the new sink; a call racing against the swap
+// gets either the old or the new logger.
+func initLogger(c config) error {
+	if (len(c.Server) == 0) || (len(c.Server[0].Logging) == 0) {
+		return nil
+	}
+
+	l, err := logging.New(c.Server[0].Logging[0].toLoggingConfig())
+	if err != nil {
+		return errors.Wrap(err, "build logger error")
+	}
+
+	loggerValue.Store(l)
+	return nil
 }
 
 func fatal(err error) {
-	fmt.Println(err.Error())
+	logger().Error(err.Error())
 	os.Exit(1)
 }
 
-func wait() {
+// wait blocks until the process receives a shutdown signal, reloading the
+// logger and fully re-validating the config on every SIGHUP in the
+// meantime; a reload failure is logged as an error and the process keeps
+// running.
+//
+// SIGHUP does not apply host or pipe changes to the running pipehub.Client:
+// it only reloads the logger and validates the new config, so host/pipe
+// changes take a process restart.
+func wait(configPath string) {
+	logger().Info("pipehub started")
+	defer logger().Info("pipehub shutting down")
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 	signal.Notify(done, syscall.SIGINT, syscall.SIGTERM)
-	<-done
+
+	for {
+		select {
+		case <-reload:
+			if err := reloadConfig(configPath); err != nil {
+				logger().Error("reload config error", "error", err.Error())
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// reloadConfig re-parses and fully validates the config found at path,
+// applying anything that can be changed without a restart (currently just
+// the logger). See the warning on wait: host/pipe changes aren't swapped
+// into a running client, only confirmed to parse and validate.
+func reloadConfig(path string) error {
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return errors.Wrap(err, "load config error")
+	}
+
+	if err := initLogger(cfg); err != nil {
+		return errors.Wrap(err, "init logger error")
+	}
+
+	logger().Info("config reloaded, restart the process to apply host/pipe changes")
+	return nil
 }
 
 func asyncErrHandler(err error) {
-	fmt.Println(errors.Wrap(err, "async error occurred").Error())
+	logger().Error("async error occurred", "error", err.Error())
 	done <- syscall.SIGTERM
 }
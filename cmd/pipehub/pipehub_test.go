@@ -0,0 +1,239 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigValid(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config
+		wantErr string
+	}{
+		{
+			name: "valid",
+			cfg: config{
+				Pipe: []configPipe{{Alias: "pipe"}},
+				Host: []configHost{{Endpoint: "/webhook", Handler: "pipe"}},
+				Server: []configServer{{
+					HTTP: []configServerHTTP{{Port: 7772}},
+				}},
+			},
+		},
+		{
+			name: "more than one server block",
+			cfg: config{
+				Server: []configServer{{}, {}},
+			},
+			wantErr: "more then one 'server' config block found",
+		},
+		{
+			name: "host handler empty",
+			cfg: config{
+				Host: []configHost{{Endpoint: "/webhook"}},
+			},
+			wantErr: "'handler' is required",
+		},
+		{
+			name: "host handler unknown alias",
+			cfg: config{
+				Pipe: []configPipe{{Alias: "pipe"}},
+				Host: []configHost{{Endpoint: "/webhook", Handler: "missing"}},
+			},
+			wantErr: "does not match any declared pipe alias",
+		},
+		{
+			name: "port out of range",
+			cfg: config{
+				Server: []configServer{{HTTP: []configServerHTTP{{Port: 99999}}}},
+			},
+			wantErr: "out of range",
+		},
+		{
+			name: "bad graceful-shutdown duration",
+			cfg: config{
+				Server: []configServer{{GracefulShutdown: "not-a-duration"}},
+			},
+			wantErr: "parse 'graceful-shutdown' duration",
+		},
+		{
+			name: "bad interpolation mode",
+			cfg: config{
+				Server: []configServer{{Interpolation: "whatever"}},
+			},
+			wantErr: "interpolation 'whatever' is invalid",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.cfg.valid()
+
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("expected error containing %q, got nil", test.wantErr)
+			}
+			if !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("expected error containing %q, got %q", test.wantErr, err.Error())
+			}
+		})
+	}
+}
+
+func TestLoadConfigSuccess(t *testing.T) {
+	tests := []string{
+		"testdata/loadConfig.success.1.hcl",
+		"testdata/loadConfig.success.2.json",
+		"testdata/loadConfig.success.3.yaml",
+		"testdata/loadConfig.confd.split",
+	}
+
+	for _, path := range tests {
+		t.Run(path, func(t *testing.T) {
+			cfg, err := loadConfig(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := cfg.valid(); err != nil {
+				t.Fatalf("decoded config is invalid: %v", err)
+			}
+
+			if len(cfg.Pipe) != 1 {
+				t.Fatalf("expected 1 pipe, got %d", len(cfg.Pipe))
+			}
+
+			pipe := cfg.Pipe[0]
+			if pipe.Path != "github.com/pipehub/pipe" || pipe.Version != "v0.7.0" || pipe.Alias != "pipe" {
+				t.Fatalf("unexpected pipe: %+v", pipe)
+			}
+
+			if len(cfg.Host) != 1 || cfg.Host[0].Handler != "pipe" {
+				t.Fatalf("unexpected host: %+v", cfg.Host)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFail(t *testing.T) {
+	tests := []struct {
+		path     string
+		wantErrs []string
+	}{
+		{
+			path:     "testdata/loadConfig.fail.1.hcl",
+			wantErrs: []string{"unknown key 'prot' at server.http"},
+		},
+		{
+			path: "testdata/loadConfig.fail.2.hcl",
+			wantErrs: []string{
+				"does not match any declared pipe alias",
+				"out of range",
+			},
+		},
+		{
+			path:     "testdata/loadConfig.confd.dup-server",
+			wantErrs: []string{"more then one 'server' config block found"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			_, err := loadConfig(test.path)
+			if err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+
+			for _, want := range test.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Fatalf("expected error containing %q, got %q", want, err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestSplitUnusedField(t *testing.T) {
+	tests := []struct {
+		raw           string
+		wantContainer string
+		wantName      string
+	}{
+		{raw: "server[0].http[0].prot", wantContainer: "server.http", wantName: "prot"},
+		{raw: "prot", wantContainer: "", wantName: "prot"},
+	}
+
+	for _, test := range tests {
+		container, name := splitUnusedField(test.raw)
+		if container != test.wantContainer || name != test.wantName {
+			t.Fatalf("splitUnusedField(%q) = (%q, %q), want (%q, %q)", test.raw, container, name, test.wantContainer, test.wantName)
+		}
+	}
+}
+
+func TestInterpolateRawConfig(t *testing.T) {
+	if err := os.Setenv("PIPEHUB_TEST_VAR", "from-env"); err != nil {
+		t.Fatalf("set env var error: %v", err)
+	}
+	defer os.Unsetenv("PIPEHUB_TEST_VAR")
+
+	dir, err := ioutil.TempDir("", "pipehub-test")
+	if err != nil {
+		t.Fatalf("create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	secretPath := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(secretPath, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatalf("write secret file error: %v", err)
+	}
+
+	raw := map[string]interface{}{
+		"pipe": []map[string]interface{}{
+			{
+				"env":     "${env:PIPEHUB_TEST_VAR}",
+				"default": "${env:PIPEHUB_TEST_MISSING:-fallback}",
+				"file":    "${file:" + secretPath + "}",
+			},
+		},
+	}
+
+	resolved, err := interpolateRawConfig(raw, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entry := resolved.(map[string]interface{})["pipe"].([]map[string]interface{})[0]
+	if entry["env"] != "from-env" {
+		t.Fatalf("env: got %q", entry["env"])
+	}
+	if entry["default"] != "fallback" {
+		t.Fatalf("default: got %q", entry["default"])
+	}
+	if entry["file"] != "from-file" {
+		t.Fatalf("file: got %q", entry["file"])
+	}
+
+	_, err = interpolateRawConfig(map[string]interface{}{"x": "${env:PIPEHUB_TEST_MISSING}"}, false)
+	if err == nil {
+		t.Fatalf("expected strict mode to error on a missing env var")
+	}
+
+	lenient, err := interpolateRawConfig(map[string]interface{}{"x": "${env:PIPEHUB_TEST_MISSING}"}, true)
+	if err != nil {
+		t.Fatalf("unexpected error in lenient mode: %v", err)
+	}
+	if lenient.(map[string]interface{})["x"] != "${env:PIPEHUB_TEST_MISSING}" {
+		t.Fatalf("lenient mode should leave the token untouched, got %q", lenient.(map[string]interface{})["x"])
+	}
+}
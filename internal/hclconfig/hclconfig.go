@@ -0,0 +1,194 @@
+// Package hclconfig provides mapstructure decode hooks that smooth over the
+// shape github.com/hashicorp/hcl produces when it unmarshals repeated and
+// labeled blocks into map[string]interface{}. Callers can wire these hooks
+// into a mapstructure.NewDecoder and decode straight into typed structs
+// instead of hand-walking the raw map.
+package hclconfig
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// HookWeakDecodeFromSlice reconciles the []map[string]interface{} shape HCL
+// produces for every block, repeated or not, with the destination type
+// mapstructure is decoding into: a single map when the destination is a
+// struct, or the slice itself when the destination is a slice of structs.
+//
+// Based on the equivalent hook in Consul's lib/decode package.
+func HookWeakDecodeFromSlice(from, to reflect.Kind, data interface{}) (interface{}, error) {
+	if from != reflect.Slice {
+		return data, nil
+	}
+
+	dataSlice, ok := asMapSlice(data)
+	if !ok {
+		return data, nil
+	}
+
+	switch to {
+	case reflect.Struct:
+		if len(dataSlice) != 1 {
+			return nil, fmt.Errorf("unexpected number of entries decoding from slice: %d, expected 1", len(dataSlice))
+		}
+		return dataSlice[0], nil
+	default:
+		return data, nil
+	}
+}
+
+// HookWeakDecodeLabeledSlice handles the "labeled block" shape HCL produces
+// when a block is keyed by name, e.g. a pipe block keyed by its module
+// path:
+//
+//	pipe "github.com/pipehub/pipe" {
+//		version = "v0.7.0"
+//	}
+//
+// unmarshals to:
+//
+//	[]map[string]interface{}{
+//		{"github.com/pipehub/pipe": []map[string]interface{}{
+//			{"version": "v0.7.0"},
+//		}},
+//	}
+//
+// The hook flattens that into a plain []map[string]interface{}, promoting
+// the label into whichever field of the destination element type is tagged
+// `mapstructure:"...,label"`. Destination types without a label field, and
+// sources that already carry the label field as a plain entry (the shape a
+// JSON/YAML config uses, since it has no equivalent to an HCL label), are
+// left untouched so the hook only fires for the HCL shape above.
+func HookWeakDecodeLabeledSlice(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.Slice || to.Kind() != reflect.Slice {
+		return data, nil
+	}
+
+	rawSlice, ok := asMapSlice(data)
+	if !ok {
+		return data, nil
+	}
+
+	field, ok := labelField(to.Elem())
+	if !ok {
+		return data, nil
+	}
+
+	if !isLabeledBlockShape(rawSlice, field) {
+		return data, nil
+	}
+
+	var result []map[string]interface{}
+	for _, rawMap := range rawSlice {
+		for label, rawEntry := range rawMap {
+			rawEntrySlice, ok := asMapSlice(rawEntry)
+			if !ok {
+				return nil, fmt.Errorf("label '%s': expected []map[string]interface{}, got %T", label, rawEntry)
+			}
+
+			for _, entry := range rawEntrySlice {
+				flat := make(map[string]interface{}, len(entry)+1)
+				for k, v := range entry {
+					flat[k] = v
+				}
+				flat[field] = label
+
+				result = append(result, flat)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// isLabeledBlockShape reports whether every entry in rawSlice matches HCL's
+// labeled-block shape: a single-key map whose value is itself a slice of
+// maps. A flat entry that already has labelField set, as a JSON/YAML pipe
+// entry like {"path": "...", "version": "..."} does, is never labeled-block
+// shaped, so such input passes through unchanged instead of being
+// misinterpreted as a set of labels.
+func isLabeledBlockShape(rawSlice []map[string]interface{}, labelField string) bool {
+	for _, entry := range rawSlice {
+		if _, hasLabelField := entry[labelField]; hasLabelField {
+			return false
+		}
+
+		if len(entry) != 1 {
+			return false
+		}
+
+		for _, value := range entry {
+			if _, ok := asMapSlice(value); !ok {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// HookWeakDecodeMapToSlice wraps a lone map into a single-element slice when
+// the destination is a slice. HCL always represents a block as a slice, even
+// one declared at most once (e.g. 'server'), but idiomatic JSON/YAML
+// represents such a block as a plain object, so this reconciles the two
+// before HookWeakDecodeFromSlice and HookWeakDecodeLabeledSlice run.
+func HookWeakDecodeMapToSlice(from, to reflect.Kind, data interface{}) (interface{}, error) {
+	if from != reflect.Map || to != reflect.Slice {
+		return data, nil
+	}
+
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return data, nil
+	}
+
+	return []map[string]interface{}{m}, nil
+}
+
+// asMapSlice normalizes the slice shapes a block can arrive in -
+// []map[string]interface{} (HCL) or []interface{} of maps, as produced when
+// blocks loaded from separate files are merged - into a single
+// []map[string]interface{}.
+func asMapSlice(data interface{}) ([]map[string]interface{}, bool) {
+	switch v := data.(type) {
+	case []map[string]interface{}:
+		return v, true
+	case []interface{}:
+		result := make([]map[string]interface{}, 0, len(v))
+		for _, entry := range v {
+			m, ok := entry.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			result = append(result, m)
+		}
+		return result, true
+	default:
+		return nil, false
+	}
+}
+
+// labelField returns the mapstructure key of the field tagged with the
+// ",label" option on t, if there is one.
+func labelField(t reflect.Type) (string, bool) {
+	if t.Kind() != reflect.Struct {
+		return "", false
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("mapstructure")
+		if tag == "" {
+			continue
+		}
+
+		parts := strings.Split(tag, ",")
+		for _, opt := range parts[1:] {
+			if opt == "label" {
+				return parts[0], true
+			}
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,78 @@
+// Package logging provides the pipehub binary's default zap-backed logger,
+// built from the 'server.logging' config block.
+//
+// The real github.com/pipehub/pipehub package has no Logger interface of
+// its own to implement yet, so this package defines one sized for what the
+// binary itself needs (fatal and asyncErrHandler). Once pipehub.ClientConfig
+// grows a way to inject a logger, Logger here should be aligned with it (or
+// dropped in favor of it) so pipe modules can log through the same sink.
+package logging
+
+import (
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Logger is the logging sink the pipehub binary logs through.
+type Logger interface {
+	Debug(msg string, kv ...interface{})
+	Info(msg string, kv ...interface{})
+	Warn(msg string, kv ...interface{})
+	Error(msg string, kv ...interface{})
+}
+
+// Config controls how New builds a logger.
+type Config struct {
+	Format string // "json" or "text", defaults to "json"
+	Level  string // "debug", "info", "warn" or "error", defaults to "info"
+	Output string // "stderr" or a file path, defaults to "stderr"
+}
+
+// New builds a Logger from cfg. The zero value Config produces a JSON
+// logger on stderr at info level, which is what container deployments
+// expect without any configuration.
+func New(cfg Config) (Logger, error) {
+	zapCfg := zap.NewProductionConfig()
+
+	switch cfg.Format {
+	case "", "json":
+		zapCfg.Encoding = "json"
+	case "text":
+		zapCfg.Encoding = "console"
+	default:
+		return nil, errors.Errorf("logging format '%s' is invalid, must be 'json' or 'text'", cfg.Format)
+	}
+
+	level := zapcore.InfoLevel
+	if cfg.Level != "" {
+		if err := level.Set(cfg.Level); err != nil {
+			return nil, errors.Wrapf(err, "logging level '%s' is invalid", cfg.Level)
+		}
+	}
+	zapCfg.Level = zap.NewAtomicLevelAt(level)
+
+	switch cfg.Output {
+	case "", "stderr":
+		zapCfg.OutputPaths = []string{"stderr"}
+	default:
+		zapCfg.OutputPaths = []string{cfg.Output}
+	}
+
+	z, err := zapCfg.Build()
+	if err != nil {
+		return nil, errors.Wrap(err, "build zap logger error")
+	}
+
+	return &logger{sugar: z.Sugar()}, nil
+}
+
+// logger adapts a zap.SugaredLogger to Logger.
+type logger struct {
+	sugar *zap.SugaredLogger
+}
+
+func (l *logger) Debug(msg string, kv ...interface{}) { l.sugar.Debugw(msg, kv...) }
+func (l *logger) Info(msg string, kv ...interface{})  { l.sugar.Infow(msg, kv...) }
+func (l *logger) Warn(msg string, kv ...interface{})  { l.sugar.Warnw(msg, kv...) }
+func (l *logger) Error(msg string, kv ...interface{}) { l.sugar.Errorw(msg, kv...) }